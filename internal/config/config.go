@@ -0,0 +1,72 @@
+/*
+Copyright 2021 Teodor Spæren
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package config loads the --config file describing the fleet of
+// projects time-since-deploy should report on.
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultEnvPrefix is used for an entry that doesn't set EnvPrefix.
+const DefaultEnvPrefix = "prod/"
+
+// Entry describes a single project to report on.
+type Entry struct {
+	// Type selects the provider: gitlab, github or gitea.
+	Type string `yaml:"Type"`
+	// URL is the base URL of the GitLab/Gitea instance. Ignored for github.
+	URL string `yaml:"URL"`
+	// TokenEnv is the environment variable holding the auth token for
+	// this entry, so different entries can use different tokens.
+	TokenEnv string `yaml:"TokenEnv"`
+	// Project is the provider-specific project identifier, e.g.
+	// "acme/api".
+	Project string `yaml:"Project"`
+	// EnvPrefix selects which deployment environments are reported on.
+	// Defaults to DefaultEnvPrefix.
+	EnvPrefix string `yaml:"EnvPrefix"`
+}
+
+// Config is the top-level shape of a --config file.
+type Config struct {
+	Projects []Entry `yaml:"projects"`
+}
+
+// Load reads and parses the config file at path.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %v", err)
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("parsing config: %v", err)
+	}
+
+	for i, e := range c.Projects {
+		if e.EnvPrefix == "" {
+			c.Projects[i].EnvPrefix = DefaultEnvPrefix
+		}
+	}
+
+	return &c, nil
+}
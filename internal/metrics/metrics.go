@@ -0,0 +1,48 @@
+/*
+Copyright 2021 Teodor Spæren
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package metrics exposes the data time-since-deploy collects as
+// Prometheus gauges, for --serve mode.
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// labels are shared by all three gauges. instance identifies the forge
+// a project's label lives on (e.g. a GitLab base URL), so two --config
+// entries can't collide just because they happen to share a Project
+// string on different instances.
+var labels = []string{"instance", "project", "service"}
+
+var (
+	DeployAgeSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deploy_age_seconds",
+		Help: "Seconds since the last deployment to this environment.",
+	}, labels)
+
+	DeployCommitsBehind = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deploy_commits_behind",
+		Help: "Number of commits on the default branch not yet deployed to this environment.",
+	}, labels)
+
+	DeployLastSuccessTimestampSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deploy_last_success_timestamp_seconds",
+		Help: "Unix timestamp of the last successful deployment to this environment.",
+	}, labels)
+)
+
+func init() {
+	prometheus.MustRegister(DeployAgeSeconds, DeployCommitsBehind, DeployLastSuccessTimestampSeconds)
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2021 Teodor Spæren
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package gitea implements provider.Provider against the Gitea API.
+//
+// Gitea has no first-class deployment/environment API like GitHub or
+// GitLab, so environments are modeled as branches named
+// "<envPrefix><service>", e.g. "prod/api" (the common GitOps convention
+// of one tracking branch per environment),
+// and a "deployment" is that branch's head commit.
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+
+	"github.com/rhermes/time-since-deploy/internal/provider"
+)
+
+// Provider implements provider.Provider using a Gitea API client.
+type Provider struct {
+	c *gitea.Client
+}
+
+// New returns a Provider talking to the Gitea instance at baseURL,
+// authenticating with token. If httpClient is non-nil, it's used for all
+// API requests instead of the SDK's default client.
+func New(baseURL, token string, httpClient *http.Client) (*Provider, error) {
+	opts := []gitea.ClientOption{gitea.SetToken(token)}
+	if httpClient != nil {
+		opts = append(opts, gitea.SetHTTPClient(httpClient))
+	}
+
+	c, err := gitea.NewClient(baseURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating gitea client: %v", err)
+	}
+
+	return &Provider{c: c}, nil
+}
+
+func (p *Provider) ResolveProject(ctx context.Context, name string) (provider.ProjectRef, error) {
+	owner, repo, ok := strings.Cut(name, "/")
+	if !ok {
+		return provider.ProjectRef{}, fmt.Errorf("project %q must be of the form owner/repo", name)
+	}
+
+	r, _, err := p.c.GetRepo(owner, repo)
+	if err != nil {
+		return provider.ProjectRef{}, fmt.Errorf("get repository: %v", err)
+	}
+
+	return provider.ProjectRef{ID: int(r.ID), Path: r.FullName, DefaultBranch: r.DefaultBranch}, nil
+}
+
+func (p *Provider) ListProdEnvironments(ctx context.Context, pr provider.ProjectRef, envPrefix string) ([]provider.EnvDep, error) {
+	owner, repo, ok := strings.Cut(pr.Path, "/")
+	if !ok {
+		return nil, fmt.Errorf("project path %q must be of the form owner/repo", pr.Path)
+	}
+
+	page := 1
+	perPage := 20
+
+	allBranches := make([]*gitea.Branch, 0)
+	for page != 0 {
+		branches, _, err := p.c.ListRepoBranches(owner, repo, gitea.ListRepoBranchesOptions{
+			ListOptions: gitea.ListOptions{Page: page, PageSize: perPage},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list branches: %v", err)
+		}
+
+		allBranches = append(allBranches, branches...)
+
+		if len(branches) < perPage {
+			page = 0
+		} else {
+			page++
+		}
+	}
+
+	envDeps := make([]provider.EnvDep, 0)
+	for _, b := range allBranches {
+		if !strings.HasPrefix(b.Name, envPrefix) {
+			continue
+		}
+		envDeps = append(envDeps, provider.EnvDep{
+			Name:     strings.TrimPrefix(b.Name, envPrefix),
+			FullName: b.Name,
+		})
+	}
+
+	return envDeps, nil
+}
+
+func (p *Provider) LastDeployment(ctx context.Context, pr provider.ProjectRef, ed provider.EnvDep) (provider.Deployment, error) {
+	owner, repo, ok := strings.Cut(pr.Path, "/")
+	if !ok {
+		return provider.Deployment{}, fmt.Errorf("project path %q must be of the form owner/repo", pr.Path)
+	}
+
+	b, _, err := p.c.GetRepoBranch(owner, repo, ed.FullName)
+	if err != nil {
+		return provider.Deployment{}, fmt.Errorf("get branch: %v", err)
+	}
+	if b.Commit == nil {
+		return provider.Deployment{}, provider.ErrNoDeployment
+	}
+
+	return provider.Deployment{
+		SHA:        b.Commit.ID,
+		ShortSHA:   shortSHA(b.Commit.ID),
+		FinishedAt: b.Commit.Timestamp,
+	}, nil
+}
+
+// Compare walks the commit history of to, page by page, collecting
+// commits until from is reached. The Gitea SDK has no dedicated compare
+// endpoint, unlike GitHub and GitLab. It returns an error, rather than a
+// partial or full-history commit list, if from is never reached — e.g.
+// because it belongs to a different branch or was dropped by a
+// force-push.
+func (p *Provider) Compare(ctx context.Context, pr provider.ProjectRef, from, to string) ([]provider.Commit, error) {
+	owner, repo, ok := strings.Cut(pr.Path, "/")
+	if !ok {
+		return nil, fmt.Errorf("project path %q must be of the form owner/repo", pr.Path)
+	}
+
+	page := 1
+	perPage := 50
+
+	commits := make([]provider.Commit, 0)
+	found := false
+	for page != 0 {
+		cs, _, err := p.c.ListRepoCommits(owner, repo, gitea.ListCommitOptions{
+			ListOptions: gitea.ListOptions{Page: page, PageSize: perPage},
+			SHA:         to,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list commits: %v", err)
+		}
+
+		for _, c := range cs {
+			if c.SHA == from {
+				found = true
+				break
+			}
+			commits = append(commits, provider.Commit{
+				AuthorEmail:  c.RepoCommit.Author.Email,
+				AuthoredDate: c.Created,
+			})
+		}
+		if found || len(cs) < perPage {
+			page = 0
+		} else {
+			page++
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("compare: %s not found in %s history", from, to)
+	}
+
+	return commits, nil
+}
+
+func shortSHA(sha string) string {
+	const n = 8
+	if len(sha) < n {
+		return sha
+	}
+	return sha[:n]
+}
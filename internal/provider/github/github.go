@@ -0,0 +1,178 @@
+/*
+Copyright 2021 Teodor Spæren
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package github implements provider.Provider against the GitHub REST
+// API, using GitHub's environments/deployments endpoints.
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v45/github"
+	"golang.org/x/oauth2"
+
+	"github.com/rhermes/time-since-deploy/internal/provider"
+)
+
+// Provider implements provider.Provider using a GitHub API client.
+type Provider struct {
+	c *github.Client
+}
+
+// New returns a Provider authenticating to github.com with token. If
+// httpClient is non-nil, its Transport is reused as the base of the
+// oauth2 transport instead of http.DefaultTransport.
+func New(ctx context.Context, token string, httpClient *http.Client) (*Provider, error) {
+	if httpClient != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	}
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	tc := oauth2.NewClient(ctx, ts)
+	return &Provider{c: github.NewClient(tc)}, nil
+}
+
+func (p *Provider) ResolveProject(ctx context.Context, name string) (provider.ProjectRef, error) {
+	owner, repo, ok := strings.Cut(name, "/")
+	if !ok {
+		return provider.ProjectRef{}, fmt.Errorf("project %q must be of the form owner/repo", name)
+	}
+
+	r, _, err := p.c.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return provider.ProjectRef{}, fmt.Errorf("get repository: %v", err)
+	}
+
+	return provider.ProjectRef{ID: int(r.GetID()), Path: r.GetFullName(), DefaultBranch: r.GetDefaultBranch()}, nil
+}
+
+func (p *Provider) ListProdEnvironments(ctx context.Context, pr provider.ProjectRef, envPrefix string) ([]provider.EnvDep, error) {
+	owner, repo, ok := strings.Cut(pr.Path, "/")
+	if !ok {
+		return nil, fmt.Errorf("project path %q must be of the form owner/repo", pr.Path)
+	}
+
+	page := 1
+	perPage := 20
+
+	envDeps := make([]provider.EnvDep, 0)
+	for page != 0 {
+		envs, r, err := p.c.Repositories.ListEnvironments(ctx, owner, repo, &github.EnvironmentListOptions{
+			ListOptions: github.ListOptions{Page: page, PerPage: perPage},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list environments: %v", err)
+		}
+
+		for _, env := range envs.Environments {
+			name := env.GetName()
+			if !strings.HasPrefix(name, envPrefix) {
+				continue
+			}
+			envDeps = append(envDeps, provider.EnvDep{
+				Name:     strings.TrimPrefix(name, envPrefix),
+				FullName: name,
+			})
+		}
+
+		page = r.NextPage
+	}
+
+	return envDeps, nil
+}
+
+// lastDeploymentLookback bounds how many of the environment's most
+// recent deployments LastDeployment will check for a successful status
+// before giving up. The newest deployment is often still pending or
+// failed, so we can't stop at it.
+const lastDeploymentLookback = 20
+
+func (p *Provider) LastDeployment(ctx context.Context, pr provider.ProjectRef, ed provider.EnvDep) (provider.Deployment, error) {
+	owner, repo, ok := strings.Cut(pr.Path, "/")
+	if !ok {
+		return provider.Deployment{}, fmt.Errorf("project path %q must be of the form owner/repo", pr.Path)
+	}
+
+	deps, _, err := p.c.Repositories.ListDeployments(ctx, owner, repo, &github.DeploymentsListOptions{
+		Environment: ed.FullName,
+		ListOptions: github.ListOptions{PerPage: lastDeploymentLookback},
+	})
+	if err != nil {
+		return provider.Deployment{}, fmt.Errorf("list deployments: %v", err)
+	}
+	if len(deps) == 0 {
+		return provider.Deployment{}, provider.ErrNoDeployment
+	}
+
+	for _, dep := range deps {
+		statuses, _, err := p.c.Repositories.ListDeploymentStatuses(ctx, owner, repo, dep.GetID(), nil)
+		if err != nil {
+			return provider.Deployment{}, fmt.Errorf("list deployment statuses: %v", err)
+		}
+
+		var finishedAt github.Timestamp
+		for _, s := range statuses {
+			if s.GetState() == "success" && s.GetUpdatedAt().After(finishedAt.Time) {
+				finishedAt = s.GetUpdatedAt()
+			}
+		}
+		if finishedAt.IsZero() {
+			continue
+		}
+
+		return provider.Deployment{
+			SHA:        dep.GetSHA(),
+			ShortSHA:   shortSHA(dep.GetSHA()),
+			FinishedAt: finishedAt.Time,
+		}, nil
+	}
+
+	return provider.Deployment{}, provider.ErrNoDeployment
+}
+
+func (p *Provider) Compare(ctx context.Context, pr provider.ProjectRef, from, to string) ([]provider.Commit, error) {
+	owner, repo, ok := strings.Cut(pr.Path, "/")
+	if !ok {
+		return nil, fmt.Errorf("project path %q must be of the form owner/repo", pr.Path)
+	}
+
+	cmp, _, err := p.c.Repositories.CompareCommits(ctx, owner, repo, from, to, nil)
+	if err != nil {
+		return nil, fmt.Errorf("compare %s..%s: %v", from, to, err)
+	}
+
+	commits := make([]provider.Commit, 0, len(cmp.Commits))
+	for _, c := range cmp.Commits {
+		author := c.GetCommit().GetAuthor()
+		commits = append(commits, provider.Commit{
+			AuthorEmail:  author.GetEmail(),
+			AuthoredDate: author.GetDate(),
+		})
+	}
+
+	return commits, nil
+}
+
+func shortSHA(sha string) string {
+	const n = 8
+	if len(sha) < n {
+		return sha
+	}
+	return sha[:n]
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 Teodor Spæren
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package gitlab implements provider.Provider against the GitLab REST API.
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/rhermes/time-since-deploy/internal/provider"
+)
+
+// Provider implements provider.Provider using a GitLab API client.
+type Provider struct {
+	c *gitlab.Client
+}
+
+// New returns a Provider talking to the GitLab instance reachable with
+// token. Set baseURL to talk to a self-hosted instance instead of
+// gitlab.com. If httpClient is non-nil, it's used for all API requests
+// instead of the SDK's default client.
+func New(token, baseURL string, httpClient *http.Client) (*Provider, error) {
+	var opts []gitlab.ClientOptionFunc
+	if baseURL != "" {
+		opts = append(opts, gitlab.WithBaseURL(baseURL))
+	}
+	if httpClient != nil {
+		opts = append(opts, gitlab.WithHTTPClient(httpClient))
+	}
+
+	c, err := gitlab.NewClient(token, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating gitlab client: %v", err)
+	}
+
+	return &Provider{c: c}, nil
+}
+
+func (p *Provider) ResolveProject(ctx context.Context, name string) (provider.ProjectRef, error) {
+	ps, r, err := p.c.Projects.ListProjects(&gitlab.ListProjectsOptions{
+		SearchNamespaces: gitlab.Bool(true),
+		Search:           gitlab.String(name),
+		Visibility:       gitlab.Visibility(gitlab.PrivateVisibility),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return provider.ProjectRef{}, fmt.Errorf("listing projects: %v", err)
+	}
+	defer r.Body.Close()
+
+	if len(ps) > 1 {
+		return provider.ProjectRef{}, fmt.Errorf("too many projects matched")
+	}
+	if len(ps) < 1 {
+		return provider.ProjectRef{}, fmt.Errorf("no projects matched")
+	}
+
+	pr := ps[0]
+	return provider.ProjectRef{ID: pr.ID, Path: pr.PathWithNamespace, DefaultBranch: pr.DefaultBranch}, nil
+}
+
+func (p *Provider) ListProdEnvironments(ctx context.Context, pr provider.ProjectRef, envPrefix string) ([]provider.EnvDep, error) {
+	page := 1
+	perPage := 20
+
+	allEnvs := make([]*gitlab.Environment, 0)
+
+	for page != 0 {
+		envs, r, err := p.c.Environments.ListEnvironments(pr.ID, &gitlab.ListEnvironmentsOptions{
+			ListOptions: gitlab.ListOptions{
+				Page:    page,
+				PerPage: perPage,
+			},
+			States: gitlab.String("available"),
+			Search: gitlab.String(envPrefix),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("list environments: %v", err)
+		}
+		defer r.Body.Close()
+
+		allEnvs = append(allEnvs, envs...)
+
+		page = r.NextPage
+	}
+
+	envDeps := make([]provider.EnvDep, 0)
+	for _, env := range allEnvs {
+		if !strings.HasPrefix(env.Name, envPrefix) {
+			continue
+		}
+		envDeps = append(envDeps, provider.EnvDep{
+			Name:     strings.TrimPrefix(env.Name, envPrefix),
+			FullName: env.Name,
+			ID:       env.ID,
+		})
+	}
+
+	return envDeps, nil
+}
+
+func (p *Provider) LastDeployment(ctx context.Context, pr provider.ProjectRef, ed provider.EnvDep) (provider.Deployment, error) {
+	penv, r, err := p.c.Environments.GetEnvironment(pr.ID, ed.ID, gitlab.WithContext(ctx))
+	if err != nil {
+		return provider.Deployment{}, fmt.Errorf("get prod environment: %v", err)
+	}
+	defer r.Body.Close()
+
+	if penv.LastDeployment == nil {
+		return provider.Deployment{}, provider.ErrNoDeployment
+	}
+
+	dep := penv.LastDeployment.Deployable
+	return provider.Deployment{
+		SHA:        dep.Commit.ID,
+		ShortSHA:   dep.Commit.ShortID,
+		FinishedAt: *dep.FinishedAt,
+	}, nil
+}
+
+func (p *Provider) Compare(ctx context.Context, pr provider.ProjectRef, from, to string) ([]provider.Commit, error) {
+	cmp, r, err := p.c.Repositories.Compare(pr.ID, &gitlab.CompareOptions{
+		From: gitlab.String(from),
+		To:   gitlab.String(to),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("compare %s..%s: %v", from, to, err)
+	}
+	defer r.Body.Close()
+
+	commits := make([]provider.Commit, 0, len(cmp.Commits))
+	for _, c := range cmp.Commits {
+		commits = append(commits, provider.Commit{
+			AuthorEmail:  c.AuthorEmail,
+			AuthoredDate: *c.AuthoredDate,
+		})
+	}
+
+	return commits, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2021 Teodor Spæren
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package provider defines the forge-agnostic view of a project's
+// deployments that time-since-deploy needs. Each supported forge (GitLab,
+// GitHub, Gitea, ...) implements Provider against its own client library.
+package provider
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoDeployment is returned by Provider.LastDeployment when it finds no
+// successful deployment for the environment.
+var ErrNoDeployment = errors.New("provider: no deployment for environment")
+
+// ProjectRef identifies a single project/repository on a forge. ID is
+// provider-specific; callers read Path and DefaultBranch.
+type ProjectRef struct {
+	ID            int
+	Path          string
+	DefaultBranch string
+}
+
+// EnvDep is a named deployment environment (e.g. "prod/api") along with
+// the provider-specific identifiers needed to look up its last
+// deployment.
+type EnvDep struct {
+	// Name is the environment name with its prefix (e.g. "prod/") stripped,
+	// for display.
+	Name string
+	// FullName is the environment's full, unstripped name, as used by
+	// providers that look deployments up by name rather than ID.
+	FullName string
+	ID       int
+}
+
+// Deployment describes the last deployment to a single environment.
+type Deployment struct {
+	SHA        string
+	ShortSHA   string
+	FinishedAt time.Time
+}
+
+// Commit is a single commit as returned by Provider.Compare.
+type Commit struct {
+	AuthorEmail  string
+	AuthoredDate time.Time
+}
+
+// Provider is implemented once per supported forge. Implementations must
+// be safe for concurrent use, since getDrifts fans out across
+// environments.
+type Provider interface {
+	// ResolveProject finds the single project matching name, returning an
+	// error if none or more than one match.
+	ResolveProject(ctx context.Context, name string) (ProjectRef, error)
+
+	// ListProdEnvironments lists the deployment environments whose name
+	// starts with envPrefix (e.g. "prod/") for the given project, with
+	// the prefix stripped from EnvDep.Name.
+	ListProdEnvironments(ctx context.Context, p ProjectRef, envPrefix string) ([]EnvDep, error)
+
+	// LastDeployment returns the most recent successful deployment to
+	// env. It returns ErrNoDeployment if it finds none; the GitHub
+	// provider only checks the environment's most recent deployments.
+	LastDeployment(ctx context.Context, p ProjectRef, env EnvDep) (Deployment, error)
+
+	// Compare returns the commits reachable from to but not from from,
+	// like `git log from..to`. The order of the returned commits is
+	// provider-specific.
+	Compare(ctx context.Context, p ProjectRef, from, to string) ([]Commit, error)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 Teodor Spæren
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package tracing wires up the OpenTelemetry SDK for time-since-deploy:
+// building the tracer provider for the configured exporter, and
+// instrumenting the HTTP clients handed to the VCS providers so outgoing
+// forge API calls show up as spans too.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// Tracer is the tracer every part of time-since-deploy starts spans from.
+var Tracer = otel.Tracer("github.com/rhermes/time-since-deploy")
+
+// Init builds and installs a global TracerProvider for the given exporter
+// ("jaeger", "otlp" or "stdout"; "none" is handled by the caller before
+// Init is ever reached). endpoint is exporter-specific: a Jaeger
+// collector URL, an OTLP/gRPC target, or ignored for stdout. It returns a
+// shutdown func that flushes and stops the provider.
+func Init(ctx context.Context, exporter, endpoint string) (shutdown func(context.Context) error, err error) {
+	exp, err := newExporter(ctx, exporter, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("building %s exporter: %v", exporter, err)
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("time-since-deploy"))
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, exporter, endpoint string) (sdktrace.SpanExporter, error) {
+	switch exporter {
+	case "jaeger":
+		opts := jaeger.WithCollectorEndpoint()
+		if endpoint != "" {
+			opts = jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(endpoint))
+		}
+		return jaeger.New(opts)
+	case "otlp":
+		copts := []otlptracegrpc.Option{otlptracegrpc.WithInsecure()}
+		if endpoint != "" {
+			copts = append(copts, otlptracegrpc.WithEndpoint(endpoint))
+		}
+		return otlptracegrpc.New(ctx, copts...)
+	case "stdout":
+		return stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown otel exporter %q", exporter)
+	}
+}
+
+// HTTPClient wraps an *http.Client so its requests are traced: each
+// outgoing request becomes a span recording DNS, connect and TLS timing,
+// nested under whatever span is active on the request's context.
+func HTTPClient(c *http.Client) *http.Client {
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	traced := *c
+	traced.Transport = otelhttp.NewTransport(&clientTraceTransport{base: base})
+	return &traced
+}
+
+// clientTraceTransport installs an otelhttptrace.ClientTrace on every
+// request before handing it to base, so the span otelhttp.Transport
+// starts gets child spans for DNS lookup, connect and TLS handshake.
+type clientTraceTransport struct {
+	base http.RoundTripper
+}
+
+func (t *clientTraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := httptrace.WithClientTrace(req.Context(), otelhttptrace.NewClientTrace(req.Context()))
+	return t.base.RoundTrip(req.WithContext(ctx))
+}
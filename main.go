@@ -20,186 +20,488 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
-	"runtime/trace"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/hako/durafmt"
-	"github.com/xanzy/go-gitlab"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rhermes/time-since-deploy/internal/config"
+	"github.com/rhermes/time-since-deploy/internal/logging"
+	"github.com/rhermes/time-since-deploy/internal/metrics"
+	"github.com/rhermes/time-since-deploy/internal/provider"
+	"github.com/rhermes/time-since-deploy/internal/provider/gitea"
+	"github.com/rhermes/time-since-deploy/internal/provider/github"
+	"github.com/rhermes/time-since-deploy/internal/provider/gitlab"
+	"github.com/rhermes/time-since-deploy/internal/tracing"
 )
 
-type EnvDep struct {
-	Name string
-	Prod int
+// compareCacheSize bounds how many (instance, project, from, to) Compare
+// results we keep in memory. Repeated runs against slow-moving envs tend
+// to compare the same handful of ranges over and over.
+const compareCacheSize = 256
+
+// compareCache memoizes Provider.Compare across the whole run. It's safe
+// for the concurrent use getDrifts makes of it.
+var compareCache, _ = lru.New(compareCacheSize)
+
+// logger is the structured logger every part of time-since-deploy logs
+// through, set up in main from -log-level/-log-format.
+var logger logging.Logger
+
+type compareKey struct {
+	instance string
+	path     string
+	from, to string
 }
 
 var (
-	projectFlag = flag.String("project", "", "selects the project to be used")
-	traceFlag   = flag.String("trace", "", "file to write trace to")
+	projectFlag      = flag.String("project", "", "selects the project to be used")
+	providerFlag     = flag.String("provider", "gitlab", "VCS provider to use: gitlab, github or gitea")
+	urlFlag          = flag.String("url", "", "base URL of the GitLab/Gitea instance (ignored for github)")
+	configFlag       = flag.String("config", "", "path to a config file describing many projects to report on, overriding -project/-provider/-url")
+	concurrencyFlag  = flag.Int("concurrency", 4, "max number of projects from -config to query concurrently")
+	serveFlag        = flag.String("serve", "", "if set, serve Prometheus metrics on this address (e.g. :9090) instead of printing a table once")
+	intervalFlag     = flag.Duration("interval", time.Minute, "how often to refresh metrics in -serve mode")
+	otelExporterFlag = flag.String("otel-exporter", "none", "OpenTelemetry trace exporter to use: jaeger, otlp, stdout or none")
+	otelEndpointFlag = flag.String("otel-endpoint", "", "collector endpoint for -otel-exporter (defaults to the exporter's usual local address)")
+	logLevelFlag     = flag.String("log-level", "info", "minimum log level to emit: debug, info, warn or error")
+	logFormatFlag    = flag.String("log-format", "text", "log output format: text or json")
 )
 
 func main() {
 	flag.Parse()
-	if *projectFlag == "" {
-		log.Fatal("project not set")
+
+	l, err := logging.New(*logLevelFlag, *logFormatFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating logger: %v\n", err)
+		os.Exit(1)
 	}
+	logger = l
+
+	ctx := context.Background()
 
-	if *traceFlag != "" {
-		f, err := os.Create(*traceFlag)
+	if *otelExporterFlag != "none" {
+		shutdown, err := tracing.Init(ctx, *otelExporterFlag, *otelEndpointFlag)
 		if err != nil {
-			log.Fatalf("couldn't open trace file: %v", err)
+			logger.Error("init tracing", "error", err)
+			os.Exit(1)
 		}
-		defer f.Close()
+		defer shutdown(ctx)
+	}
+
+	ctx, span := tracing.Tracer.Start(ctx, "full-run")
+	defer span.End()
+
+	if *serveFlag != "" {
+		serve(ctx, *serveFlag, *intervalFlag)
+		return
+	}
 
-		if err := trace.Start(f); err != nil {
-			log.Fatalf("couldn't start trace: %v", err)
+	if *configFlag != "" {
+		if err := runFromConfig(ctx, *configFlag); err != nil {
+			logger.Error("run from config", "error", err)
+			os.Exit(1)
 		}
-		defer trace.Stop()
+		return
 	}
 
-	ctx := context.Background()
+	if *projectFlag == "" {
+		logger.Error("project not set")
+		os.Exit(1)
+	}
 
-	ctx, tsk := trace.NewTask(ctx, "full-run")
-	defer tsk.End()
+	p, err := newProvider(ctx, *providerFlag, *urlFlag, defaultTokenEnv(*providerFlag))
+	if err != nil {
+		logger.Error("creating provider", "error", err)
+		os.Exit(1)
+	}
 
-	token, ok := os.LookupEnv("GITLAB_TOKEN")
-	if !ok {
-		log.Fatal("token not set\n")
+	if err := runProject(ctx, p, instanceLabel(*providerFlag, *urlFlag), *projectFlag, config.DefaultEnvPrefix); err != nil {
+		logger.Error("run project", "error", err)
+		os.Exit(1)
+	}
+}
+
+// serve runs the --serve mode: it exposes the current metrics on
+// addr+"/metrics" and refreshes them every interval until the process is
+// killed.
+func serve(ctx context.Context, addr string, interval time.Duration) {
+	http.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			logger.Error("serve metrics", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	for {
+		if err := scrapeOnce(ctx); err != nil {
+			logger.Warn("scrape", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
 	}
+}
 
-	c, err := gitlab.NewClient(token)
+// scrapeOnce refreshes the metrics for every configured project, once.
+func scrapeOnce(ctx context.Context) error {
+	if *configFlag != "" {
+		cfg, err := config.Load(*configFlag)
+		if err != nil {
+			return err
+		}
+
+		sem := make(chan struct{}, *concurrencyFlag)
+		var wg sync.WaitGroup
+		for _, e := range cfg.Projects {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(e config.Entry) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				tokenEnv := e.TokenEnv
+				if tokenEnv == "" {
+					tokenEnv = defaultTokenEnv(e.Type)
+				}
+
+				p, err := newProvider(ctx, e.Type, e.URL, tokenEnv)
+				if err != nil {
+					logger.Warn("creating provider", "project", e.Project, "error", err)
+					return
+				}
+
+				if err := reportProjectMetrics(ctx, p, instanceLabel(e.Type, e.URL), e.Project, e.EnvPrefix); err != nil {
+					logger.Warn("report project metrics", "project", e.Project, "error", err)
+				}
+			}(e)
+		}
+		wg.Wait()
+		return nil
+	}
+
+	p, err := newProvider(ctx, *providerFlag, *urlFlag, defaultTokenEnv(*providerFlag))
 	if err != nil {
-		log.Fatalf("creating client: %v", err)
+		return fmt.Errorf("creating provider: %v", err)
+	}
+
+	return reportProjectMetrics(ctx, p, instanceLabel(*providerFlag, *urlFlag), *projectFlag, config.DefaultEnvPrefix)
+}
+
+// instanceLabel identifies the forge instance a project's metrics come
+// from, so two projects that happen to share a name on different
+// GitLab/Gitea servers (or on GitHub vs. a self-hosted forge) don't
+// collide under the same Prometheus labels.
+func instanceLabel(typ, url string) string {
+	if url == "" {
+		return typ
 	}
+	return typ + ":" + url
+}
 
-	pid, err := getProjectID(ctx, c)
+// reportProjectMetrics updates the deploy_* gauges for every environment
+// of a single project on the given forge instance.
+func reportProjectMetrics(ctx context.Context, p provider.Provider, instance, projectName, envPrefix string) error {
+	pr, err := getProjectID(ctx, p, projectName)
 	if err != nil {
-		log.Fatalf("get project id: %v", err)
+		return fmt.Errorf("get project id: %v", err)
 	}
 
-	envDeps, err := getEnvs(ctx, c, pid)
+	envDeps, err := getEnvs(ctx, p, pr, envPrefix)
 	if err != nil {
-		log.Fatalf("get envs: %v", err)
+		return fmt.Errorf("get envs: %v", err)
 	}
 
-	if err := getDrifts(ctx, c, pid, envDeps); err != nil {
-		log.Fatalf("get drifts: %v", err)
+	var wg sync.WaitGroup
+	for _, ed := range envDeps {
+		wg.Add(1)
+		go func(ed provider.EnvDep) {
+			defer wg.Done()
+
+			ds, err := computeDrift(ctx, p, instance, pr, ed)
+			if err != nil {
+				if err != provider.ErrNoDeployment {
+					logger.Warn("get drift", "project", projectName, "service", ed.Name, "error", err)
+				}
+				return
+			}
+
+			labels := prometheus.Labels{"instance": instance, "project": projectName, "service": ed.Name}
+			metrics.DeployAgeSeconds.With(labels).Set(time.Since(ds.dep.FinishedAt).Seconds())
+			metrics.DeployLastSuccessTimestampSeconds.With(labels).Set(float64(ds.dep.FinishedAt.Unix()))
+			if ds.haveCommits {
+				metrics.DeployCommitsBehind.With(labels).Set(float64(ds.commitsBehind))
+			}
+
+			logger.Debug("drift computed",
+				"service", ed.Name,
+				"project", projectName,
+				"sha", ds.dep.ShortSHA,
+				"age_seconds", time.Since(ds.dep.FinishedAt).Seconds(),
+				"commits_behind", ds.commitsBehind,
+			)
+		}(ed)
 	}
+	wg.Wait()
+
+	return nil
 }
 
-func getDrifts(ctx context.Context, c *gitlab.Client, pid int, envDeps []EnvDep) error {
-	ctx, tsk := trace.NewTask(ctx, "get-drifts")
-	defer tsk.End()
+// runFromConfig reports on every project in the config file at path,
+// using a bounded worker pool so a large fleet doesn't fan out unlimited
+// goroutines against a shared forge instance.
+func runFromConfig(ctx context.Context, path string) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
 
+	sem := make(chan struct{}, *concurrencyFlag)
 	var wg sync.WaitGroup
 
-	fmt.Printf("SERVICE           | SHORT SHA | LAST DEPLOY\n")
-	for _, envDep := range envDeps {
+	for _, e := range cfg.Projects {
 		wg.Add(1)
-		go func(ed EnvDep) {
+		sem <- struct{}{}
+		go func(e config.Entry) {
 			defer wg.Done()
+			defer func() { <-sem }()
 
-			if err := getDrift(ctx, c, pid, ed); err != nil {
-				log.Printf("get drift %s: %v", ed.Name, err)
+			if err := runConfigEntry(ctx, e); err != nil {
+				logger.Warn("run config entry", "project", e.Project, "error", err)
 			}
-		}(envDep)
+		}(e)
 	}
 
 	wg.Wait()
 	return nil
 }
 
-func getDrift(ctx context.Context, c *gitlab.Client, pid int, ed EnvDep) error {
-	ctx, tsk := trace.NewTask(ctx, "get-drift")
-	defer tsk.End()
+func runConfigEntry(ctx context.Context, e config.Entry) error {
+	tokenEnv := e.TokenEnv
+	if tokenEnv == "" {
+		tokenEnv = defaultTokenEnv(e.Type)
+	}
 
-	trace.Log(ctx, "service", ed.Name)
-	penv, r, err := c.Environments.GetEnvironment(pid, ed.Prod, gitlab.WithContext(ctx))
+	p, err := newProvider(ctx, e.Type, e.URL, tokenEnv)
 	if err != nil {
-		return fmt.Errorf("get prod environment: %v", err)
+		return fmt.Errorf("creating provider: %v", err)
 	}
-	defer r.Body.Close()
 
-	if penv.LastDeployment == nil {
-		return nil
-	}
+	fmt.Printf("\n=== %s ===\n", e.Project)
+	return runProject(ctx, p, instanceLabel(e.Type, e.URL), e.Project, e.EnvPrefix)
+}
 
-	pdep := penv.LastDeployment.Deployable
+// runProject prints the deploy-drift table for a single project.
+func runProject(ctx context.Context, p provider.Provider, instance, projectName, envPrefix string) error {
+	pr, err := getProjectID(ctx, p, projectName)
+	if err != nil {
+		return fmt.Errorf("get project id: %v", err)
+	}
 
-	lastDep := time.Since(*pdep.FinishedAt)
-	dd := durafmt.Parse(lastDep).LimitFirstN(2)
-	fmt.Printf("%-18s| %s  | %s\n", ed.Name, pdep.Commit.ShortID, dd.String())
+	envDeps, err := getEnvs(ctx, p, pr, envPrefix)
+	if err != nil {
+		return fmt.Errorf("get envs: %v", err)
+	}
 
-	return nil
+	return getDrifts(ctx, p, instance, pr, envDeps)
 }
 
-func getEnvs(ctx context.Context, c *gitlab.Client, pid int) ([]EnvDep, error) {
-	ctx, tsk := trace.NewTask(ctx, "get-envs")
-	defer tsk.End()
+// defaultTokenEnv returns the conventional token environment variable
+// for a provider type.
+func defaultTokenEnv(typ string) string {
+	switch typ {
+	case "gitlab":
+		return "GITLAB_TOKEN"
+	case "github":
+		return "GITHUB_TOKEN"
+	case "gitea":
+		return "GITEA_TOKEN"
+	default:
+		return ""
+	}
+}
 
-	page := 1
-	perPage := 20
+// newProvider builds the provider.Provider selected by typ, reading its
+// auth token from the tokenEnv environment variable. Its API requests
+// are made with an HTTP client instrumented for tracing, so they show up
+// as child spans of whatever span is active on the context each request
+// is made with. The gitea provider passes no context to its SDK, so its
+// request spans are not nested under the caller's span.
+func newProvider(ctx context.Context, typ, baseURL, tokenEnv string) (provider.Provider, error) {
+	token, ok := os.LookupEnv(tokenEnv)
+	if !ok {
+		return nil, fmt.Errorf("%s not set", tokenEnv)
+	}
 
-	allEnvs := make([]*gitlab.Environment, 0)
+	httpClient := tracing.HTTPClient(&http.Client{})
 
-	for page != 0 {
-		envs, r, err := c.Environments.ListEnvironments(pid, &gitlab.ListEnvironmentsOptions{
-			ListOptions: gitlab.ListOptions{
-				Page:    page,
-				PerPage: perPage,
-			},
-			States: gitlab.String("available"),
-			Search: gitlab.String("prod/"),
-		}, gitlab.WithContext(ctx))
-		if err != nil {
-			return nil, fmt.Errorf("list environments: %v", err)
+	switch typ {
+	case "gitlab":
+		return gitlab.New(token, baseURL, httpClient)
+	case "github":
+		return github.New(ctx, token, httpClient)
+	case "gitea":
+		if baseURL == "" {
+			return nil, fmt.Errorf("-url is required for the gitea provider")
 		}
-		defer r.Body.Close()
+		return gitea.New(baseURL, token, httpClient)
+	default:
+		return nil, fmt.Errorf("unknown provider %q", typ)
+	}
+}
+
+func getDrifts(ctx context.Context, p provider.Provider, instance string, pr provider.ProjectRef, envDeps []provider.EnvDep) error {
+	ctx, span := tracing.Tracer.Start(ctx, "get-drifts")
+	defer span.End()
 
-		allEnvs = append(allEnvs, envs...)
+	var wg sync.WaitGroup
 
-		page = r.NextPage
+	fmt.Printf("SERVICE           | SHORT SHA | LAST DEPLOY          | COMMITS BEHIND | AUTHORS BEHIND | OLDEST UNSHIPPED\n")
+	for _, envDep := range envDeps {
+		wg.Add(1)
+		go func(ed provider.EnvDep) {
+			defer wg.Done()
+
+			if err := getDrift(ctx, p, instance, pr, ed); err != nil {
+				logger.Warn("get drift", "project", pr.Path, "service", ed.Name, "error", err)
+			}
+		}(envDep)
 	}
 
-	servDeps := make([]EnvDep, 0)
-	for _, env := range allEnvs {
-		parts := strings.Split(env.Name, "/")
-		if len(parts) != 2 {
-			continue
+	wg.Wait()
+	return nil
+}
+
+// driftStats is the forge-agnostic result of comparing an environment's
+// last deployment against its project's default branch.
+type driftStats struct {
+	dep             provider.Deployment
+	commitsBehind   int
+	authorsBehind   int
+	oldestUnshipped time.Duration // zero if unknown
+	haveCommits     bool
+}
+
+func getDrift(ctx context.Context, p provider.Provider, instance string, pr provider.ProjectRef, ed provider.EnvDep) error {
+	ctx, span := tracing.Tracer.Start(ctx, "get-drift", trace.WithAttributes(attribute.String("service", ed.Name)))
+	defer span.End()
+
+	ds, err := computeDrift(ctx, p, instance, pr, ed)
+	if err != nil {
+		if err == provider.ErrNoDeployment {
+			return nil
 		}
-		servDeps = append(servDeps, EnvDep{
-			Name: parts[1],
-			Prod: env.ID,
-		})
+		return err
+	}
+
+	dd := durafmt.Parse(time.Since(ds.dep.FinishedAt)).LimitFirstN(2)
+
+	commitsBehind, authorsBehind, oldestUnshipped := "-", "-", "-"
+	if ds.haveCommits {
+		commitsBehind = fmt.Sprintf("%d", ds.commitsBehind)
+		authorsBehind = fmt.Sprintf("%d", ds.authorsBehind)
+		oldestUnshipped = durafmt.Parse(ds.oldestUnshipped).LimitFirstN(2).String()
 	}
 
-	return servDeps, nil
+	fmt.Printf("%-18s| %s  | %-21s| %-15s| %-15s| %s\n",
+		ed.Name, ds.dep.ShortSHA, dd.String(), commitsBehind, authorsBehind, oldestUnshipped)
+
+	logger.Debug("drift computed",
+		"service", ed.Name,
+		"project", pr.Path,
+		"sha", ds.dep.ShortSHA,
+		"age_seconds", time.Since(ds.dep.FinishedAt).Seconds(),
+		"commits_behind", ds.commitsBehind,
+	)
+
+	return nil
 }
 
-func getProjectID(ctx context.Context, c *gitlab.Client) (int, error) {
-	ctx, tsk := trace.NewTask(ctx, "get-project-id")
-	defer tsk.End()
+// computeDrift fetches an environment's last deployment and, if the
+// project has a default branch, how far behind it that deployment is.
+// It returns provider.ErrNoDeployment if p.LastDeployment finds no
+// successful deployment for the environment.
+func computeDrift(ctx context.Context, p provider.Provider, instance string, pr provider.ProjectRef, ed provider.EnvDep) (driftStats, error) {
+	dep, err := p.LastDeployment(ctx, pr, ed)
+	if err != nil {
+		if err == provider.ErrNoDeployment {
+			return driftStats{}, provider.ErrNoDeployment
+		}
+		return driftStats{}, fmt.Errorf("last deployment: %v", err)
+	}
+
+	ds := driftStats{dep: dep}
 
-	ps, r, err := c.Projects.ListProjects(&gitlab.ListProjectsOptions{
-		SearchNamespaces: gitlab.Bool(true),
-		Search:           gitlab.String(*projectFlag),
-		Visibility:       gitlab.Visibility(gitlab.PrivateVisibility),
-	}, gitlab.WithContext(ctx))
+	if pr.DefaultBranch == "" {
+		return ds, nil
+	}
+
+	commits, err := compareCached(ctx, p, instance, pr, dep.SHA, pr.DefaultBranch)
 	if err != nil {
-		return 0, fmt.Errorf("listing projects: %v", err)
+		logger.Warn("compare", "project", pr.Path, "service", ed.Name, "error", err)
+		return ds, nil
+	}
+
+	authors := make(map[string]struct{}, len(commits))
+	var oldest time.Time
+	for _, c := range commits {
+		authors[c.AuthorEmail] = struct{}{}
+		if oldest.IsZero() || c.AuthoredDate.Before(oldest) {
+			oldest = c.AuthoredDate
+		}
 	}
-	defer r.Body.Close()
 
-	if len(ps) > 1 {
-		return 0, fmt.Errorf("too many projects matched")
+	ds.haveCommits = true
+	ds.commitsBehind = len(commits)
+	ds.authorsBehind = len(authors)
+	if !oldest.IsZero() {
+		ds.oldestUnshipped = time.Since(oldest)
 	}
-	if len(ps) < 1 {
-		return 0, fmt.Errorf("no projects matched")
+
+	return ds, nil
+}
+
+// compareCached wraps Provider.Compare with an in-memory cache keyed by
+// (instance, project, from, to), since repeated runs against slow-moving
+// environments would otherwise re-fetch the same range every time.
+// instance is folded into the key so two --config entries that happen
+// to share a project path on different forge instances can't collide.
+func compareCached(ctx context.Context, p provider.Provider, instance string, pr provider.ProjectRef, from, to string) ([]provider.Commit, error) {
+	key := compareKey{instance: instance, path: pr.Path, from: from, to: to}
+	if v, ok := compareCache.Get(key); ok {
+		return v.([]provider.Commit), nil
+	}
+
+	commits, err := p.Compare(ctx, pr, from, to)
+	if err != nil {
+		return nil, err
 	}
 
-	p := ps[0]
-	return p.ID, nil
+	compareCache.Add(key, commits)
+	return commits, nil
+}
+
+func getEnvs(ctx context.Context, p provider.Provider, pr provider.ProjectRef, envPrefix string) ([]provider.EnvDep, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "get-envs")
+	defer span.End()
+
+	return p.ListProdEnvironments(ctx, pr, envPrefix)
+}
+
+func getProjectID(ctx context.Context, p provider.Provider, projectName string) (provider.ProjectRef, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "get-project-id")
+	defer span.End()
+
+	return p.ResolveProject(ctx, projectName)
 }